@@ -0,0 +1,119 @@
+// Package archiver defines a pluggable archive format for TarCache: an
+// Archiver interface with tar+gzip, tar+zstd, and uncompressed tar
+// implementations, so the format is a startup-time choice instead of a
+// constant.
+package archiver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/pusher/bytecount"
+)
+
+// Archiver accumulates named byte streams into a single streaming archive
+// file on disk, tracking its own size as it grows so callers can decide when
+// it is big enough to upload.
+type Archiver interface {
+	// Add appends r to the archive under name, using info (the source file's
+	// os.Stat result) to populate the member's size, mode, and mtime.
+	Add(name string, info os.FileInfo, r io.Reader) error
+	// Close finishes writing the archive and returns a reader positioned at
+	// the start of its bytes, ready to be uploaded. After Close, Add must
+	// not be called again.
+	Close() (io.Reader, error)
+	// Size returns the number of archive bytes written so far.
+	Size() bytecount.ByteCount
+	// Cleanup releases whatever on-disk resources back the archive. Callers
+	// should call it once they are done with whatever Close returned.
+	Cleanup() error
+}
+
+// Factory creates Archivers that all use the same algorithm, and knows the
+// file extension that algorithm implies, so callers can name uploaded
+// objects correctly and set their Content-Encoding.
+type Factory interface {
+	New() (Archiver, error)
+	Extension() string
+}
+
+// compressWriteCloser is satisfied by compress/gzip.Writer,
+// klauspost/pgzip.Writer, klauspost/compress/zstd.Encoder, and the no-op
+// wrapper the uncompressed tar Factory uses.
+type compressWriteCloser interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// countingWriter wraps an io.Writer and tracks the number of bytes that have
+// passed through it, so an Archiver can report its size without stat-ing the
+// underlying spool file.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// tarArchiver is the Archiver implementation shared by every algorithm in
+// this package; only the compressor sitting between the tar stream and the
+// spool file differs between them.
+type tarArchiver struct {
+	spoolFile  *os.File
+	size       *countingWriter
+	compressor compressWriteCloser
+	tarWriter  *tar.Writer
+}
+
+func (a *tarArchiver) Add(name string, info os.FileInfo, r io.Reader) error {
+	// Build the header from the source file's real mode and mtime, as Docker's
+	// CopyFileWithTar does, instead of hardcoding placeholder metadata.
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("could not build a tar header for %s: %v", name, err)
+	}
+	header.Name = name
+	if err := a.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("could not write the tar header for %s: %v", name, err)
+	}
+	if _, err := io.Copy(a.tarWriter, r); err != nil {
+		return fmt.Errorf("could not write the contents of %s into the archive: %v", name, err)
+	}
+	// Flush the data so that our tracked size is accurate.
+	if err := a.tarWriter.Flush(); err != nil {
+		return fmt.Errorf("could not flush the tar writer: %v", err)
+	}
+	if err := a.compressor.Flush(); err != nil {
+		return fmt.Errorf("could not flush the compressor: %v", err)
+	}
+	return nil
+}
+
+func (a *tarArchiver) Size() bytecount.ByteCount {
+	return bytecount.ByteCount(a.size.count)
+}
+
+func (a *tarArchiver) Close() (io.Reader, error) {
+	if err := a.tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("could not close the tar writer: %v", err)
+	}
+	if err := a.compressor.Close(); err != nil {
+		return nil, fmt.Errorf("could not close the compressor: %v", err)
+	}
+	if _, err := a.spoolFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not rewind the spool file: %v", err)
+	}
+	return a.spoolFile, nil
+}
+
+func (a *tarArchiver) Cleanup() error {
+	a.spoolFile.Close()
+	return os.Remove(a.spoolFile.Name())
+}
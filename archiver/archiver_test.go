@@ -0,0 +1,119 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func roundTrip(t *testing.T, factory Factory, name, contents string) []byte {
+	t.Helper()
+	archive, err := factory.New()
+	if err != nil {
+		t.Fatalf("could not create an archive: %q", err)
+	}
+	defer archive.Cleanup()
+
+	info := writeTempFileForStat(t, contents)
+	if err := archive.Add(name, info, bytes.NewReader([]byte(contents))); err != nil {
+		t.Fatalf("could not add %s: %q", name, err)
+	}
+	if archive.Size() == 0 {
+		t.Error("expected a non-zero archive size after Add")
+	}
+	reader, err := archive.Close()
+	if err != nil {
+		t.Fatalf("could not close the archive: %q", err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("could not read the archive: %q", err)
+	}
+	return data
+}
+
+// writeTempFileForStat writes contents to a temp file and returns its
+// os.FileInfo, so tests can exercise Add with a real mode and mtime instead
+// of a fake os.FileInfo.
+func writeTempFileForStat(t *testing.T, contents string) os.FileInfo {
+	t.Helper()
+	f, err := ioutil.TempFile("", "archiver_test")
+	if err != nil {
+		t.Fatalf("could not create a temp file: %q", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf("could not write to the temp file: %q", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("could not stat the temp file: %q", err)
+	}
+	return info
+}
+
+func TestGzipFactoryRoundTrip(t *testing.T) {
+	data := roundTrip(t, NewGzipFactory(DefaultGzipOptions()), "hello.txt", "hello world")
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("archive was not valid gzip: %q", err)
+	}
+	assertTarContains(t, gzr, "hello.txt", "hello world")
+}
+
+func TestZstdFactoryRoundTrip(t *testing.T) {
+	data := roundTrip(t, NewZstdFactory(DefaultZstdOptions()), "hello.txt", "hello world")
+	zr, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("archive was not valid zstd: %q", err)
+	}
+	defer zr.Close()
+	assertTarContains(t, zr, "hello.txt", "hello world")
+}
+
+func TestPlainFactoryRoundTrip(t *testing.T) {
+	data := roundTrip(t, NewPlainFactory(0), "hello.txt", "hello world")
+	assertTarContains(t, bytes.NewReader(data), "hello.txt", "hello world")
+}
+
+func assertTarContains(t *testing.T, r io.Reader, name, contents string) {
+	t.Helper()
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("could not read the first tar member: %q", err)
+	}
+	if hdr.Name != name {
+		t.Errorf("expected tar member named %q, got %q", name, hdr.Name)
+	}
+	got, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("could not read the tar member's contents: %q", err)
+	}
+	if string(got) != contents {
+		t.Errorf("expected contents %q, got %q", contents, got)
+	}
+}
+
+func TestFactoryExtensions(t *testing.T) {
+	cases := []struct {
+		factory Factory
+		want    string
+	}{
+		{NewGzipFactory(DefaultGzipOptions()), ".tar.gz"},
+		{NewZstdFactory(DefaultZstdOptions()), ".tar.zst"},
+		{NewPlainFactory(0), ".tar"},
+	}
+	for _, c := range cases {
+		if got := c.factory.Extension(); got != c.want {
+			t.Errorf("expected extension %q, got %q", c.want, got)
+		}
+	}
+}
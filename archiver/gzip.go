@@ -0,0 +1,84 @@
+package archiver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+
+	"github.com/klauspost/pgzip"
+
+	"github.com/m-lab/pusher/ratelimit"
+)
+
+// GzipOptions configures the tar+gzip Factory.
+type GzipOptions struct {
+	Level int
+	// Concurrency is the number of goroutines pgzip may use to compress
+	// blocks in parallel. Values <= 1 make compression single-threaded.
+	Concurrency int
+	// RateLimitBytesPerSecond caps the I/O rate of the compressed output
+	// stream. Zero means unlimited.
+	RateLimitBytesPerSecond int64
+}
+
+// DefaultGzipOptions returns parallel gzip at the default compression level,
+// with concurrency bounded to the number of available CPUs (capped at 8,
+// since more goroutines rarely help gzip's block size and just cost
+// memory), and no rate limiting.
+func DefaultGzipOptions() GzipOptions {
+	return GzipOptions{
+		Level:       pgzip.DefaultCompression,
+		Concurrency: maxConcurrency(8),
+	}
+}
+
+func maxConcurrency(max int) int {
+	n := runtime.NumCPU()
+	if n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+type gzipFactory struct {
+	opts GzipOptions
+}
+
+// NewGzipFactory returns a Factory that produces tar+gzip Archivers,
+// compressed in parallel via pgzip.
+func NewGzipFactory(opts GzipOptions) Factory {
+	return &gzipFactory{opts: opts}
+}
+
+func (f *gzipFactory) Extension() string { return ".tar.gz" }
+
+func (f *gzipFactory) New() (Archiver, error) {
+	spoolFile, err := ioutil.TempFile("", "pusher-archive-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create a spool file: %v", err)
+	}
+	limited := ratelimit.NewWriter(spoolFile, f.opts.RateLimitBytesPerSecond)
+	counting := &countingWriter{w: limited}
+	gzipWriter, err := pgzip.NewWriterLevel(counting, f.opts.Level)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a pgzip writer: %v", err)
+	}
+	concurrency := f.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// 1MB blocks is pgzip's own default; we only override concurrency.
+	if err := gzipWriter.SetConcurrency(1<<20, concurrency); err != nil {
+		return nil, fmt.Errorf("could not configure pgzip concurrency: %v", err)
+	}
+	return &tarArchiver{
+		spoolFile:  spoolFile,
+		size:       counting,
+		compressor: gzipWriter,
+		tarWriter:  tar.NewWriter(gzipWriter),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// representativeData approximates a typical M-Lab measurement file: mostly
+// compressible text with a little high-entropy noise mixed in.
+func representativeData(b *testing.B, size int) []byte {
+	b.Helper()
+	data := make([]byte, size)
+	noise := make([]byte, size/10)
+	if _, err := rand.Read(noise); err != nil {
+		b.Fatalf("could not generate random data: %q", err)
+	}
+	for i := range data {
+		if i < len(noise) {
+			data[i] = noise[i]
+		} else {
+			data[i] = byte('a' + i%26)
+		}
+	}
+	return data
+}
+
+func BenchmarkSingleThreadedGzip(b *testing.B) {
+	data := representativeData(b, 16<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gzipWriter := gzip.NewWriter(ioutil.Discard)
+		if _, err := gzipWriter.Write(data); err != nil {
+			b.Fatalf("write failed: %q", err)
+		}
+		if err := gzipWriter.Close(); err != nil {
+			b.Fatalf("close failed: %q", err)
+		}
+	}
+}
+
+func BenchmarkParallelGzip(b *testing.B) {
+	data := representativeData(b, 16<<20)
+	factory := NewGzipFactory(DefaultGzipOptions())
+	tmp, err := ioutil.TempFile("", "gzip_bench")
+	if err != nil {
+		b.Fatalf("could not create a temp file: %q", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		b.Fatalf("could not write to the temp file: %q", err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		b.Fatalf("could not stat the temp file: %q", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		archive, err := factory.New()
+		if err != nil {
+			b.Fatalf("could not create an archive: %q", err)
+		}
+		if err := archive.Add("data", info, bytes.NewReader(data)); err != nil {
+			b.Fatalf("add failed: %q", err)
+		}
+		if _, err := archive.Close(); err != nil {
+			b.Fatalf("close failed: %q", err)
+		}
+		archive.Cleanup()
+	}
+}
@@ -0,0 +1,47 @@
+package archiver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/m-lab/pusher/ratelimit"
+)
+
+// identityCompressor adapts a plain io.Writer to compressWriteCloser for the
+// uncompressed tar Factory, which has nothing to flush or close of its own.
+type identityCompressor struct {
+	io.Writer
+}
+
+func (identityCompressor) Flush() error { return nil }
+func (identityCompressor) Close() error { return nil }
+
+type plainFactory struct {
+	rateLimitBytesPerSecond int64
+}
+
+// NewPlainFactory returns a Factory that produces uncompressed tar
+// Archivers. This trades a much larger upload for skipping compression
+// entirely, which is occasionally useful for already-compressed payloads.
+func NewPlainFactory(rateLimitBytesPerSecond int64) Factory {
+	return &plainFactory{rateLimitBytesPerSecond: rateLimitBytesPerSecond}
+}
+
+func (f *plainFactory) Extension() string { return ".tar" }
+
+func (f *plainFactory) New() (Archiver, error) {
+	spoolFile, err := ioutil.TempFile("", "pusher-archive-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create a spool file: %v", err)
+	}
+	limited := ratelimit.NewWriter(spoolFile, f.rateLimitBytesPerSecond)
+	counting := &countingWriter{w: limited}
+	return &tarArchiver{
+		spoolFile:  spoolFile,
+		size:       counting,
+		compressor: identityCompressor{counting},
+		tarWriter:  tar.NewWriter(counting),
+	}, nil
+}
@@ -1,9 +1,11 @@
 // Package backoff provides a tool for repeatedly calling a function until it
-// returns a nil error.  It implements exponential backoff with a defined
-// maximum value, along with some time randomization.
+// returns a nil error.  It implements a two-tier exponential backoff: a short
+// inner cycle that absorbs transient errors, and a longer outer cycle (with
+// jitter) that tolerates sustained incidents without retrying forever.
 package backoff
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
 	"time"
@@ -19,10 +21,25 @@ var (
 		},
 		[]string{"function"},
 	)
-	pusherMaxRetries = prometheus.NewCounterVec(
+	pusherInnerCycleExhausted = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "pusher_max_retries_total",
-			Help: "The number of times we have hit the max backoff time when retrying the function",
+			Name: "pusher_retry_inner_cycle_exhausted_total",
+			Help: "The number of times the inner retry cycle ran out of time and escalated to the outer cycle",
+		},
+		[]string{"function"},
+	)
+	pusherOuterCycleGiveup = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pusher_retry_outer_cycle_giveup_total",
+			Help: "The number of times the outer retry cycle ran out of time and Retry gave up",
+		},
+		[]string{"function"},
+	)
+	pusherRetryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "pusher_retry_duration_seconds",
+			Help:    "How long Retry spent retrying before the function succeeded or it gave up",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
 		},
 		[]string{"function"},
 	)
@@ -30,22 +47,90 @@ var (
 
 func init() {
 	prometheus.MustRegister(pusherRetries)
-	prometheus.MustRegister(pusherMaxRetries)
+	prometheus.MustRegister(pusherInnerCycleExhausted)
+	prometheus.MustRegister(pusherOuterCycleGiveup)
+	prometheus.MustRegister(pusherRetryDuration)
+}
+
+// RetryPolicy configures the two retry cycles used by Retry. The inner cycle
+// retries with exponential backoff capped at MaxAttemptBackoff until
+// InnerDeadline has elapsed, for ordinary transient errors. If the inner
+// cycle is exhausted, Retry escalates to the outer cycle, which keeps
+// retrying every OuterInterval (plus up to Jitter of randomness) until
+// OuterDeadline has elapsed, for sustained incidents. If the outer deadline is
+// also reached, Retry gives up and returns an error.
+type RetryPolicy struct {
+	InitialBackoff    time.Duration
+	MaxAttemptBackoff time.Duration
+	OuterInterval     time.Duration
+	InnerDeadline     time.Duration
+	OuterDeadline     time.Duration
+	Jitter            time.Duration
 }
 
-// Retry retries calling a function until the function returns a non-nil error.
-// It increments two prometheus counters to keep track of how many errors it has
-// seen: one for all errors, and just when the max error count has been reached.
-// The counters are indexed by the passed-in label. For best results, make sure
-// that maxBackoff > 2*initialBackoff.
-func Retry(f func() error, initialBackoff, maxBackoff time.Duration, label string) {
-	waitTime := initialBackoff
-	for err := f(); err != nil; err = f() {
-		if waitTime > maxBackoff {
-			pusherMaxRetries.WithLabelValues(label).Inc()
-			ns := maxBackoff.Nanoseconds()
-			waitTime = time.Duration((ns/2)+rand.Int63n(ns/2)) * time.Nanosecond
+// NewRetryPolicy returns a RetryPolicy with the given parameters.
+func NewRetryPolicy(initialBackoff, maxAttemptBackoff, outerInterval, innerDeadline, outerDeadline, jitter time.Duration) RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff:    initialBackoff,
+		MaxAttemptBackoff: maxAttemptBackoff,
+		OuterInterval:     outerInterval,
+		InnerDeadline:     innerDeadline,
+		OuterDeadline:     outerDeadline,
+		Jitter:            jitter,
+	}
+}
+
+// DefaultRetryPolicy returns the policy pusher uses unless a caller overrides
+// it: a one-minute inner cycle backing off up to 5 seconds per attempt,
+// escalating to an hour-long outer cycle that retries every 5 minutes (plus
+// up to a minute of jitter) before giving up entirely.
+func DefaultRetryPolicy() RetryPolicy {
+	return NewRetryPolicy(
+		100*time.Millisecond,
+		5*time.Second,
+		5*time.Minute,
+		time.Minute,
+		time.Hour,
+		time.Minute,
+	)
+}
+
+// Retry retries calling a function until the function returns a nil error, an
+// inner cycle of transient-error backoff elapses (at which point it escalates
+// to a longer outer cycle with jitter), or the outer cycle also elapses (at
+// which point Retry gives up and returns a non-nil error). It increments
+// prometheus counters so operators can distinguish ordinary retries from
+// inner-cycle escalation and outer-cycle giveup; all are indexed by label.
+func Retry(f func() error, policy RetryPolicy, label string) error {
+	start := time.Now()
+	waitTime := policy.InitialBackoff
+	inOuterCycle := false
+	for {
+		err := f()
+		if err == nil {
+			pusherRetryDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+			return nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed > policy.OuterDeadline {
+			pusherOuterCycleGiveup.WithLabelValues(label).Inc()
+			pusherRetryDuration.WithLabelValues(label).Observe(elapsed.Seconds())
+			return fmt.Errorf("giving up on %s after %s (error: %q)", label, elapsed, err)
+		}
+		if !inOuterCycle && elapsed > policy.InnerDeadline {
+			pusherInnerCycleExhausted.WithLabelValues(label).Inc()
+			inOuterCycle = true
+		}
+		if inOuterCycle {
+			waitTime = policy.OuterInterval
+		} else if waitTime > policy.MaxAttemptBackoff {
+			waitTime = policy.MaxAttemptBackoff
 		}
+		if inOuterCycle && policy.Jitter > 0 {
+			waitTime += time.Duration(rand.Int63n(policy.Jitter.Nanoseconds()))
+		}
+
 		log.Printf("Call to %s failed (error: %q), will retry after %s", label, err, waitTime.String())
 		pusherRetries.WithLabelValues(label).Inc()
 		time.Sleep(waitTime)
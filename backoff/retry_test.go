@@ -0,0 +1,50 @@
+package backoff
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetrySucceedsAfterFlapping(t *testing.T) {
+	policy := NewRetryPolicy(time.Millisecond, 5*time.Millisecond, 5*time.Millisecond, 20*time.Millisecond, 50*time.Millisecond, time.Millisecond)
+	calls := 0
+	err := Retry(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("simulated transient error")
+		}
+		return nil
+	}, policy, "TestRetrySucceedsAfterFlapping")
+	if err != nil {
+		t.Errorf("Retry should have succeeded, but got error: %q", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryEscalatesToOuterCycle(t *testing.T) {
+	policy := NewRetryPolicy(time.Millisecond, 2*time.Millisecond, 2*time.Millisecond, 10*time.Millisecond, 100*time.Millisecond, time.Millisecond)
+	calls := 0
+	err := Retry(func() error {
+		calls++
+		if calls < 10 {
+			return errors.New("simulated sustained incident")
+		}
+		return nil
+	}, policy, "TestRetryEscalatesToOuterCycle")
+	if err != nil {
+		t.Errorf("Retry should eventually have succeeded, but got error: %q", err)
+	}
+}
+
+func TestRetryGivesUpAfterOuterDeadline(t *testing.T) {
+	policy := NewRetryPolicy(time.Millisecond, 2*time.Millisecond, 2*time.Millisecond, 5*time.Millisecond, 20*time.Millisecond, time.Millisecond)
+	err := Retry(func() error {
+		return errors.New("permanent error")
+	}, policy, "TestRetryGivesUpAfterOuterDeadline")
+	if err == nil {
+		t.Error("Retry should have given up and returned an error, but returned nil")
+	}
+}
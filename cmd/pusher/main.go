@@ -0,0 +1,61 @@
+// Command pusher watches a directory for files and periodically bundles and
+// uploads them. This is the minimal wiring that installs signal handling and
+// drives TarCache.ListenForever; a production deployment should replace
+// stdoutUploader below with an Uploader that actually ships bytes somewhere
+// (e.g. to GCS), since no such implementation ships in this tree yet.
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/m-lab/pusher/archiver"
+	"github.com/m-lab/pusher/backoff"
+	"github.com/m-lab/pusher/bytecount"
+	"github.com/m-lab/pusher/tarcache"
+)
+
+var (
+	directory       = flag.String("directory", "", "The directory to watch for files to upload.")
+	sizeThreshold   = flag.Int64("size_threshold_bytes", int64(100*bytecount.ByteCount(1<<20)), "Upload a tarfile once it reaches this size.")
+	ageThreshold    = flag.Duration("age_threshold", 5*time.Minute, "Upload a tarfile once this long has passed since its first member was added.")
+	hardKillTimeout = flag.Duration("hard_kill_timeout", 30*time.Second, "Force-exit if shutdown has not completed this long after a termination signal.")
+)
+
+// stdoutUploader is a placeholder Uploader that only logs; it exists so this
+// binary links and runs, not as a real upload path.
+type stdoutUploader struct{}
+
+func (stdoutUploader) UploadStream(contents io.Reader, size int64, extension string) error {
+	data, err := ioutil.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	log.Printf("Would upload %d bytes with extension %s\n", len(data), extension)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if *directory == "" {
+		log.Fatal("-directory is required")
+	}
+
+	tarCache, fileChannel := tarcache.New(
+		*directory,
+		bytecount.ByteCount(*sizeThreshold),
+		*ageThreshold,
+		stdoutUploader{},
+		backoff.DefaultRetryPolicy(),
+		archiver.NewGzipFactory(archiver.DefaultGzipOptions()),
+	)
+	_ = fileChannel // a real deployment wires a file discovery loop to send to this channel.
+
+	ctx, stop := tarcache.NotifyContext(*hardKillTimeout)
+	defer stop()
+
+	tarCache.ListenForever(ctx)
+}
@@ -0,0 +1,28 @@
+// Package counter provides an io.Reader wrapper that reports the bytes
+// flowing through it via a callback, so callers can observe the progress of
+// a long-running stream (e.g. an upload) without changing whatever is
+// actually consuming it.
+package counter
+
+import "io"
+
+// Reader wraps an io.Reader and invokes onRead with the number of bytes
+// transferred by every Read call that returns data.
+type Reader struct {
+	r      io.Reader
+	onRead func(delta int64)
+}
+
+// NewReader returns an io.Reader that reads from r and calls onRead with
+// each Read's byte count as the bytes flow through.
+func NewReader(r io.Reader, onRead func(delta int64)) *Reader {
+	return &Reader{r: r, onRead: onRead}
+}
+
+func (c *Reader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && c.onRead != nil {
+		c.onRead(int64(n))
+	}
+	return n, err
+}
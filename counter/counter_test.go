@@ -0,0 +1,39 @@
+package counter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReaderReportsDeltas(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4096)
+	var total int64
+	var calls int
+	r := NewReader(bytes.NewReader(data), func(delta int64) {
+		total += delta
+		calls++
+	})
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll returned an error: %q", err)
+	}
+	if len(read) != len(data) {
+		t.Errorf("expected to read %d bytes, got %d", len(data), len(read))
+	}
+	if total != int64(len(data)) {
+		t.Errorf("expected callback deltas to sum to %d, got %d", len(data), total)
+	}
+	if calls == 0 {
+		t.Error("expected onRead to be called at least once")
+	}
+}
+
+func TestReaderPropagatesEOF(t *testing.T) {
+	r := NewReader(bytes.NewReader(nil), func(int64) {})
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
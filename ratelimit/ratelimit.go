@@ -0,0 +1,75 @@
+// Package ratelimit provides a token-bucket io.Writer wrapper so a single
+// TarCache can cap the I/O it uses to compress and upload tarfiles, which
+// matters when several pusher instances share a node's disk and network.
+package ratelimit
+
+import (
+	"io"
+	"time"
+)
+
+// writer wraps an io.Writer and sleeps as needed to keep the average
+// throughput at or below bytesPerSecond.
+type writer struct {
+	w              io.Writer
+	bytesPerSecond int64
+	tokens         int64
+	last           time.Time
+}
+
+// NewWriter returns an io.Writer that caps writes to w at bytesPerSecond
+// bytes per second using a token bucket: one second's worth of tokens is
+// available up front, and more accrues continuously as time passes. A
+// bytesPerSecond of zero or less disables rate limiting and returns w
+// unchanged.
+func NewWriter(w io.Writer, bytesPerSecond int64) io.Writer {
+	if bytesPerSecond <= 0 {
+		return w
+	}
+	return &writer{
+		w:              w,
+		bytesPerSecond: bytesPerSecond,
+		tokens:         bytesPerSecond,
+		last:           time.Now(),
+	}
+}
+
+func (r *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		r.refill()
+		if r.tokens <= 0 {
+			time.Sleep(time.Second / 10)
+			continue
+		}
+		chunk := p
+		if int64(len(chunk)) > r.tokens {
+			chunk = chunk[:r.tokens]
+		}
+		n, err := r.w.Write(chunk)
+		r.tokens -= int64(n)
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// refill credits whole tokens accrued since the last credit, capped at one
+// second's worth so a long idle period can't build up an unbounded burst
+// allowance. Time too short to earn a whole token carries over to the next
+// call.
+func (r *writer) refill() {
+	now := time.Now()
+	accrued := int64(now.Sub(r.last).Seconds() * float64(r.bytesPerSecond))
+	if accrued <= 0 {
+		return
+	}
+	r.last = now
+	r.tokens += accrued
+	if r.tokens > r.bytesPerSecond {
+		r.tokens = r.bytesPerSecond
+	}
+}
@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNewWriterUnlimitedReturnsInput(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 0)
+	if w != io.Writer(&buf) {
+		t.Error("NewWriter with a non-positive rate should return the underlying writer unchanged")
+	}
+}
+
+func TestWriterEventuallyWritesEverything(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1<<20) // 1MB/s is plenty fast for a small test payload.
+	payload := bytes.Repeat([]byte("x"), 4096)
+	n, err := w.Write(payload)
+	if err != nil {
+		t.Fatalf("Write returned an error: %q", err)
+	}
+	if n != len(payload) {
+		t.Errorf("expected to write %d bytes, wrote %d", len(payload), n)
+	}
+	if buf.Len() != len(payload) {
+		t.Errorf("expected underlying writer to receive %d bytes, got %d", len(payload), buf.Len())
+	}
+}
@@ -0,0 +1,54 @@
+package tarcache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	pusherShutdownFlushSuccess = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pusher_shutdown_flush_success_total",
+			Help: "The number of times a graceful shutdown successfully flushed the in-progress tarfile",
+		},
+	)
+	pusherShutdownFlushTimeout = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pusher_shutdown_flush_timeout_total",
+			Help: "The number of times a graceful shutdown's file-channel drain hit its deadline before the channel closed",
+		},
+	)
+	pusherShutdownFlushFailure = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pusher_shutdown_flush_failure_total",
+			Help: "The number of times a graceful shutdown's final upload gave up without succeeding",
+		},
+	)
+	pusherUploadBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pusher_upload_bytes_total",
+			Help: "The number of bytes uploaded",
+		},
+		[]string{"function"},
+	)
+	pusherUploadDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "pusher_upload_duration_seconds",
+			Help:    "How long each call to upload a tarfile took, including retries",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"function"},
+	)
+	pusherUploadInflightBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pusher_upload_inflight_bytes",
+			Help: "The total size of tarfiles currently in the process of being uploaded",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(pusherShutdownFlushSuccess)
+	prometheus.MustRegister(pusherShutdownFlushTimeout)
+	prometheus.MustRegister(pusherShutdownFlushFailure)
+	prometheus.MustRegister(pusherUploadBytesTotal)
+	prometheus.MustRegister(pusherUploadDurationSeconds)
+	prometheus.MustRegister(pusherUploadInflightBytes)
+}
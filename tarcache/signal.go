@@ -0,0 +1,47 @@
+package tarcache
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// NotifyContext returns a context.Context that is canceled when the process
+// receives SIGINT, SIGTERM, or SIGQUIT, along with a stop function the
+// caller should defer to stop listening for those signals and release
+// resources. Pass the returned context to ListenForever so it flushes the
+// in-progress tarfile before exiting. If hardKillTimeout elapses after an
+// actual termination signal is received without the process having exited on
+// its own (for example because the shutdown drain is stuck), the process is
+// forcibly terminated so a wedged pusher can't block a container from
+// shutting down. The hard-kill watchdog only arms when a real signal is
+// received; calling the returned stop function directly (the caller's
+// ordinary deferred cleanup) does not arm it.
+func NotifyContext(hardKillTimeout time.Duration) (context.Context, context.CancelFunc) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case sig := <-sigChan:
+			log.Printf("Received %s; beginning shutdown.\n", sig)
+			cancel()
+			timer := time.NewTimer(hardKillTimeout)
+			defer timer.Stop()
+			<-timer.C
+			log.Printf("Shutdown did not complete within %s of receiving a termination signal; forcing exit.\n", hardKillTimeout)
+			os.Exit(1)
+		case <-ctx.Done():
+			// stop was called directly, not in response to a signal; there is
+			// nothing to watch for.
+		}
+	}()
+	stop := func() {
+		signal.Stop(sigChan)
+		cancel()
+	}
+	return ctx, stop
+}
@@ -0,0 +1,32 @@
+package tarcache
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyContextStopDoesNotArmWatchdog(t *testing.T) {
+	var logOutput bytes.Buffer
+	log.SetOutput(&logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, stop := NotifyContext(20 * time.Millisecond)
+	stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ctx was not canceled after calling stop")
+	}
+
+	// If the watchdog incorrectly armed on stop(), it would have fired and
+	// logged its "forcing exit" message well within this window.
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(logOutput.String(), "forcing exit") {
+		t.Error("watchdog armed after stop() was called directly, not in response to a signal")
+	}
+}
@@ -1,21 +1,35 @@
 package tarcache
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
-	"io/ioutil"
+	"context"
+	"io"
 	"log"
-	"math/rand"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/m-lab/pusher/archiver"
+	"github.com/m-lab/pusher/backoff"
 	"github.com/m-lab/pusher/bytecount"
+	"github.com/m-lab/pusher/counter"
 	"github.com/m-lab/pusher/fileinfo"
 	"github.com/m-lab/pusher/uploader"
 )
 
+// DefaultShutdownDrainTimeout bounds how long ListenForever will keep
+// draining the file channel for already-discovered files after shutdown
+// begins, before giving up and flushing whatever tarfile it has.
+const DefaultShutdownDrainTimeout = 30 * time.Second
+
+// progressLogThreshold is the tarfile size above which uploadAndDelete logs
+// periodic progress lines, so operators can tell a slow upload of a huge
+// tarfile apart from a stuck one.
+const progressLogThreshold = 100 * bytecount.ByteCount(1<<20)
+
+// progressLogInterval is how often uploadAndDelete logs progress for
+// uploads above progressLogThreshold.
+const progressLogInterval = 10 * time.Second
+
 // TODO: All calls to log.Print* should have corresponding prometheus counters
 // that get incremented.
 
@@ -25,26 +39,35 @@ import (
 // To upload a lot of tarfiles, you should only have to create one TarCache.
 // The TarCache takes care of creating each tarfile and getting it uploaded.
 type TarCache struct {
-	fileChannel    <-chan *fileinfo.LocalDataFile
-	currentTarfile *tarfile
-	sizeThreshold  bytecount.ByteCount
-	ageThreshold   time.Duration
-	rootDirectory  string
-	uploader       uploader.Uploader
+	fileChannel     <-chan *fileinfo.LocalDataFile
+	currentTarfile  *tarfile
+	sizeThreshold   bytecount.ByteCount
+	ageThreshold    time.Duration
+	rootDirectory   string
+	uploader        uploader.Uploader
+	retryPolicy     backoff.RetryPolicy
+	archiverFactory archiver.Factory
+	drainTimeout    time.Duration
 }
 
-// A tarfile represents a single tar file containing data for upload
+// A tarfile represents a single archive-in-progress containing data for
+// upload. Its contents are streamed straight through archive to a spool file
+// on disk instead of being held in memory, so the size of an in-progress
+// tarfile is bounded only by disk space.
 type tarfile struct {
-	timeout    <-chan time.Time
-	members    []*fileinfo.LocalDataFile
-	contents   *bytes.Buffer
-	tarWriter  *tar.Writer
-	gzipWriter *gzip.Writer
+	timeout <-chan time.Time
+	members []*fileinfo.LocalDataFile
+	archive archiver.Archiver
 }
 
 // New creates a new TarCache object and returns a pointer to it and the
-// channel used to send data to the TarCache.
-func New(rootDirectory string, sizeThreshold bytecount.ByteCount, ageThreshold time.Duration, uploader uploader.Uploader) (*TarCache, chan<- *fileinfo.LocalDataFile) {
+// channel used to send data to the TarCache. The passed-in retryPolicy
+// governs how uploadAndDelete retries a failed upload; pass
+// backoff.DefaultRetryPolicy() unless a caller has a specific reason not to.
+// The passed-in archiverFactory governs how each tarfile is built and
+// compressed; pass archiver.NewGzipFactory(archiver.DefaultGzipOptions())
+// unless a caller has a specific reason not to.
+func New(rootDirectory string, sizeThreshold bytecount.ByteCount, ageThreshold time.Duration, uploader uploader.Uploader, retryPolicy backoff.RetryPolicy, archiverFactory archiver.Factory) (*TarCache, chan<- *fileinfo.LocalDataFile) {
 	if !strings.HasSuffix(rootDirectory, "/") {
 		rootDirectory += "/"
 	}
@@ -52,37 +75,44 @@ func New(rootDirectory string, sizeThreshold bytecount.ByteCount, ageThreshold t
 	// discovery event response times from any file processing times.
 	fileChannel := make(chan *fileinfo.LocalDataFile, 1000000)
 	tarCache := &TarCache{
-		fileChannel:    fileChannel,
-		rootDirectory:  rootDirectory,
-		currentTarfile: newTarfile(),
-		sizeThreshold:  sizeThreshold,
-		ageThreshold:   ageThreshold,
-		uploader:       uploader,
+		fileChannel:     fileChannel,
+		rootDirectory:   rootDirectory,
+		currentTarfile:  newTarfile(archiverFactory),
+		sizeThreshold:   sizeThreshold,
+		ageThreshold:    ageThreshold,
+		uploader:        uploader,
+		retryPolicy:     retryPolicy,
+		archiverFactory: archiverFactory,
+		drainTimeout:    DefaultShutdownDrainTimeout,
 	}
 	return tarCache, fileChannel
 }
 
-func newTarfile() *tarfile {
-	// TODO: profile and determine if preallocation is a good idea.
-	buffer := &bytes.Buffer{}
-	gzipWriter := gzip.NewWriter(buffer)
-	tarWriter := tar.NewWriter(gzipWriter)
-	return &tarfile{
-		contents:   buffer,
-		tarWriter:  tarWriter,
-		gzipWriter: gzipWriter,
+func newTarfile(archiverFactory archiver.Factory) *tarfile {
+	archive, err := archiverFactory.New()
+	if err != nil {
+		log.Fatalf("Could not create a new archive (error: %q)\n", err)
 	}
+	return &tarfile{archive: archive}
 }
 
 // ListenForever waits for new files and then uploads them. Using this approach
 // allows us to ensure that all file processing happens in this single thread,
 // no matter whether the processing is happening due to age thresholds or size
-// thresholds.
-func (t *TarCache) ListenForever() {
+// thresholds. When ctx is canceled, ListenForever stops its normal loop, keeps
+// adding files from the file channel until the channel is closed or
+// drainTimeout elapses, uploads whatever tarfile results, and returns, so
+// that a terminated
+// container doesn't leave partially-tarred data on disk to be re-discovered
+// on restart.
+func (t *TarCache) ListenForever(ctx context.Context) {
 	channelOpen := true
 	for channelOpen {
 		var dataFile *fileinfo.LocalDataFile
 		select {
+		case <-ctx.Done():
+			t.shutdown()
+			return
 		case <-t.currentTarfile.timeout:
 			t.uploadAndDelete()
 		case dataFile, channelOpen = <-t.fileChannel:
@@ -90,76 +120,140 @@ func (t *TarCache) ListenForever() {
 				t.add(dataFile)
 			}
 		}
+	}
+}
 
+// shutdown adds files from the file channel until the channel is closed or
+// drainTimeout elapses (so an open, empty channel makes it wait the full
+// drainTimeout), then flushes the in-progress tarfile (if it has any
+// members) so it gets uploaded instead of left for a future restart to
+// rediscover.
+func (t *TarCache) shutdown() {
+	log.Println("Shutdown signal received: draining the file channel and flushing the in-progress tarfile.")
+	deadline := time.After(t.drainTimeout)
+drain:
+	for {
+		select {
+		case dataFile, channelOpen := <-t.fileChannel:
+			if !channelOpen {
+				break drain
+			}
+			t.add(dataFile)
+		case <-deadline:
+			log.Printf("Shutdown drain timeout of %s reached; some discovered files may be left unprocessed.\n", t.drainTimeout)
+			pusherShutdownFlushTimeout.Inc()
+			t.flush()
+			return
+		}
+	}
+	t.flush()
+}
+
+// flush uploads the current tarfile, if it has any members, and records the
+// outcome in the pusher_shutdown_flush_* metrics.
+func (t *TarCache) flush() {
+	if len(t.currentTarfile.members) == 0 {
+		pusherShutdownFlushSuccess.Inc()
+		return
+	}
+	if err := t.uploadAndDelete(); err != nil {
+		pusherShutdownFlushFailure.Inc()
+		return
 	}
+	pusherShutdownFlushSuccess.Inc()
 }
 
 // Add adds the contents of a file to the underlying tarfile.  It possibly
 // calls uploadAndDelete() afterwards.
 func (t *TarCache) add(file *fileinfo.LocalDataFile) {
-	contents, err := ioutil.ReadFile(file.AbsoluteFileName)
+	fd, err := os.Open(file.AbsoluteFileName)
 	if err != nil {
-		log.Printf("Could not read %s (error: %q)\n", file.AbsoluteFileName, err)
+		log.Printf("Could not open %s (error: %q)\n", file.AbsoluteFileName, err)
 		return
 	}
-	header := &tar.Header{
-		Name: strings.TrimPrefix(file.AbsoluteFileName, t.rootDirectory),
-		Mode: 0666,
-		Size: int64(len(contents)),
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		log.Printf("Could not stat %s (error: %q)\n", file.AbsoluteFileName, err)
+		return
 	}
+	name := strings.TrimPrefix(file.AbsoluteFileName, t.rootDirectory)
 
-	// It's not at all clear how any of the below errors might be recovered from,
-	// so we treat them as unrecoverable, call log.Fatal, and hope that the errors
-	// are transient and will not re-occur when the container is restarted.
+	// It's not at all clear how this error might be recovered from, so we
+	// treat it as unrecoverable, call log.Fatal, and hope that the error is
+	// transient and will not re-occur when the container is restarted.
 	tf := t.currentTarfile
-	if err = tf.tarWriter.WriteHeader(header); err != nil {
-		log.Fatalf("Could not write the tarfile header for %s (error: %q)\n", file.AbsoluteFileName, err)
-	}
-	if _, err = tf.tarWriter.Write(contents); err != nil {
-		log.Fatalf("Could not write the tarfile contents for %s (error: %q)\n", file.AbsoluteFileName, err)
-	}
-	// Flush the data so that our in-memory filesize is accurate.
-	if err = tf.tarWriter.Flush(); err != nil {
-		log.Fatalf("Could not flush the tarWriter (error: %q)\n", err)
-	}
-	if err = tf.gzipWriter.Flush(); err != nil {
-		log.Fatalf("Could not flush the gzipWriter (error: %q)\n", err)
+	if err := tf.archive.Add(name, info, fd); err != nil {
+		log.Fatalf("Could not add %s to the archive (error: %q)\n", file.AbsoluteFileName, err)
 	}
 	if len(tf.members) == 0 {
 		timer := time.NewTimer(t.ageThreshold)
 		tf.timeout = timer.C
 	}
 	tf.members = append(tf.members, file)
-	if bytecount.ByteCount(tf.contents.Len()) > t.sizeThreshold {
+	if tf.archive.Size() > t.sizeThreshold {
 		t.uploadAndDelete()
 	}
 }
 
-// Upload the buffer, delete the component files, start a new buffer.
-func (t *TarCache) uploadAndDelete() {
-	t.currentTarfile.uploadAndDelete(t.uploader)
-	t.currentTarfile = newTarfile()
+// Upload the archive, delete the component files, start a new tarfile.
+func (t *TarCache) uploadAndDelete() error {
+	err := t.currentTarfile.uploadAndDelete(t.uploader, t.retryPolicy, t.archiverFactory.Extension())
+	t.currentTarfile = newTarfile(t.archiverFactory)
+	return err
 }
 
-// Upload the contents of the tarfile and then delete the component files.
-func (t *tarfile) uploadAndDelete(uploader uploader.Uploader) {
+// Upload the contents of the archive and then delete the component files. If
+// the upload never succeeds within retryPolicy's outer deadline, the
+// tarfile's component files are left undeleted on disk and will be picked up
+// again on the next restart, rather than blocking this goroutine forever,
+// and the outer deadline's error is returned to the caller.
+func (t *tarfile) uploadAndDelete(uploader uploader.Uploader, retryPolicy backoff.RetryPolicy, extension string) error {
 	if len(t.members) == 0 {
 		log.Println("uploadAndDelete called on an empty tarfile.")
-		return
+		return nil
+	}
+	reader, err := t.archive.Close()
+	if err != nil {
+		log.Fatalf("Could not close the archive (error: %q)\n", err)
 	}
-	t.tarWriter.Close()
-	t.gzipWriter.Close()
-	backoff := time.Duration(100) * time.Millisecond
-	for err := uploader.Upload(t.contents); err != nil; err = uploader.Upload(t.contents) {
-		log.Printf("Error uploading: %q, will retry after %s\n", err, backoff.String())
-		time.Sleep(backoff)
-		backoff = time.Duration(backoff.Seconds()*2) * time.Second
-		// The maximum retry interval is every five minutes. Once five minutes has
-		// been reached, wait for five minutes plus a random number of seconds.
-		if backoff.Minutes() > 5 {
-			log.Printf("Maximim upload retry backoff has been reached.")
-			backoff = time.Duration(300+(rand.Int()%60)) * time.Second
+	defer func() {
+		if err := t.archive.Cleanup(); err != nil {
+			log.Printf("Failed to clean up the archive (error: %q)\n", err)
 		}
+	}()
+	seeker, seekable := reader.(io.Seeker)
+
+	const label = "tarfile.uploadAndDelete"
+	size := int64(t.archive.Size())
+	logProgress := t.archive.Size() > progressLogThreshold
+
+	pusherUploadInflightBytes.Add(float64(size))
+	defer pusherUploadInflightBytes.Sub(float64(size))
+
+	start := time.Now()
+	uploadErr := backoff.Retry(func() error {
+		if seekable {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		var transferred int64
+		lastLog := time.Now()
+		progressReader := counter.NewReader(reader, func(delta int64) {
+			transferred += delta
+			pusherUploadBytesTotal.WithLabelValues(label).Add(float64(delta))
+			if logProgress && time.Since(lastLog) > progressLogInterval {
+				log.Printf("Upload progress: %d/%d bytes (%.1f%%)\n", transferred, size, 100*float64(transferred)/float64(size))
+				lastLog = time.Now()
+			}
+		})
+		return uploader.UploadStream(progressReader, size, extension)
+	}, retryPolicy, label)
+	pusherUploadDurationSeconds.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if uploadErr != nil {
+		log.Printf("Giving up on uploading tarfile (error: %q); leaving %d files on disk for later retry\n", uploadErr, len(t.members))
+		return uploadErr
 	}
 	for _, file := range t.members {
 		log.Printf("Removing %s\n", file.AbsoluteFileName)
@@ -168,4 +262,5 @@ func (t *tarfile) uploadAndDelete(uploader uploader.Uploader) {
 			log.Printf("Failed to remove %s (error: %q)\n", file.AbsoluteFileName, err)
 		}
 	}
-}
\ No newline at end of file
+	return nil
+}
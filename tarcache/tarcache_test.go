@@ -0,0 +1,173 @@
+package tarcache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/m-lab/pusher/archiver"
+	"github.com/m-lab/pusher/backoff"
+	"github.com/m-lab/pusher/bytecount"
+	"github.com/m-lab/pusher/fileinfo"
+)
+
+type fakeUploader struct {
+	uploaded [][]byte
+}
+
+func (f *fakeUploader) UploadStream(contents io.Reader, size int64, extension string) error {
+	data, err := ioutil.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	f.uploaded = append(f.uploaded, data)
+	return nil
+}
+
+func writeTempFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := dir + "/" + name
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("could not write temp file: %q", err)
+	}
+	return path
+}
+
+func TestListenForeverFlushesOnShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarcache_test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTempFile(t, dir, "data.txt", "hello world")
+	fu := &fakeUploader{}
+	tc, fileChannel := New(dir, bytecount.ByteCount(1<<30), time.Hour, fu, backoff.DefaultRetryPolicy(), archiver.NewGzipFactory(archiver.DefaultGzipOptions()))
+	tc.drainTimeout = 2 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tc.ListenForever(ctx)
+		close(done)
+	}()
+
+	fileChannel <- &fileinfo.LocalDataFile{AbsoluteFileName: path}
+	time.Sleep(50 * time.Millisecond) // give the listener goroutine time to add the file
+	cancel()
+	close(fileChannel)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenForever did not return after shutdown")
+	}
+
+	if len(fu.uploaded) != 1 {
+		t.Fatalf("expected exactly one upload on shutdown, got %d", len(fu.uploaded))
+	}
+	if !bytes.Contains(untarFirstMember(t, fu.uploaded[0]), []byte("hello world")) {
+		t.Error("uploaded tarfile did not contain the expected file contents")
+	}
+}
+
+// TestShutdownDrainsFilesArrivingBeforeDeadline reproduces the scenario where
+// a file is still in flight from the discovery loop when shutdown begins: it
+// must be picked up and included in the uploaded tarfile as long as it
+// arrives before drainTimeout, even though it is sent after shutdown began.
+func TestShutdownDrainsFilesArrivingBeforeDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarcache_test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %q", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path1 := writeTempFile(t, dir, "first.txt", "first file")
+	path2 := writeTempFile(t, dir, "second.txt", "second file")
+	fu := &fakeUploader{}
+	tc, fileChannel := New(dir, bytecount.ByteCount(1<<30), time.Hour, fu, backoff.DefaultRetryPolicy(), archiver.NewGzipFactory(archiver.DefaultGzipOptions()))
+	tc.drainTimeout = 2 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tc.ListenForever(ctx)
+		close(done)
+	}()
+
+	fileChannel <- &fileinfo.LocalDataFile{AbsoluteFileName: path1}
+	time.Sleep(50 * time.Millisecond) // give the listener goroutine time to add the file
+	cancel()
+	// Simulate the discovery loop handing off one more file while the drain
+	// is in progress, well inside drainTimeout.
+	time.Sleep(200 * time.Millisecond)
+	fileChannel <- &fileinfo.LocalDataFile{AbsoluteFileName: path2}
+	close(fileChannel)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenForever did not return after shutdown")
+	}
+
+	if len(fu.uploaded) != 1 {
+		t.Fatalf("expected exactly one upload on shutdown, got %d", len(fu.uploaded))
+	}
+	contents := untarAllMembers(t, fu.uploaded[0])
+	if !bytes.Contains(contents, []byte("first file")) {
+		t.Error("uploaded tarfile did not contain the first file's contents")
+	}
+	if !bytes.Contains(contents, []byte("second file")) {
+		t.Error("uploaded tarfile did not contain the second file, which arrived during the drain window")
+	}
+}
+
+func untarFirstMember(t *testing.T, tarGz []byte) []byte {
+	t.Helper()
+	gzr, err := gzip.NewReader(bytes.NewReader(tarGz))
+	if err != nil {
+		t.Fatalf("could not read the uploaded tarfile as gzip: %q", err)
+	}
+	tr := tar.NewReader(gzr)
+	if _, err := tr.Next(); err != nil {
+		t.Fatalf("could not read the first tar member: %q", err)
+	}
+	contents, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("could not read the first tar member's contents: %q", err)
+	}
+	return contents
+}
+
+// untarAllMembers gunzips and untars tarGz, concatenating the contents of
+// every member.
+func untarAllMembers(t *testing.T, tarGz []byte) []byte {
+	t.Helper()
+	gzr, err := gzip.NewReader(bytes.NewReader(tarGz))
+	if err != nil {
+		t.Fatalf("could not read the uploaded tarfile as gzip: %q", err)
+	}
+	tr := tar.NewReader(gzr)
+	var all []byte
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read a tar member: %q", err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("could not read a tar member's contents: %q", err)
+		}
+		all = append(all, contents...)
+	}
+	return all
+}
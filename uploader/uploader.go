@@ -0,0 +1,20 @@
+// Package uploader defines the interface TarCache uses to ship completed
+// tarfiles off to remote storage. This tree does not yet include a
+// production implementation (e.g. GCS-backed); see cmd/pusher for the
+// placeholder used to wire ListenForever until one exists.
+package uploader
+
+import (
+	"io"
+)
+
+// Uploader is the interface by which a tarfile's contents get uploaded to
+// whatever long-term storage backs this pusher instance. contents is read
+// through to EOF; size is the number of bytes contents will yield, which
+// implementations may use to choose a chunked or resumable upload strategy
+// instead of buffering the whole stream. extension is the archive's file
+// extension (e.g. ".tar.gz", ".tar.zst", ".tar"), which implementations use
+// to name the uploaded object and to set its Content-Encoding.
+type Uploader interface {
+	UploadStream(contents io.Reader, size int64, extension string) error
+}